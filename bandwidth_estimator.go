@@ -0,0 +1,240 @@
+package gortsplib
+
+import (
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+// bweMinBitrate and bweMaxBitrate bound the estimate produced by
+// bandwidthEstimator, to avoid it collapsing to zero or escaping to an
+// unusable value after a burst of atypical feedback.
+const (
+	bweMinBitrate = 50_000     // 50 kbit/s
+	bweMaxBitrate = 50_000_000 // 50 Mbit/s
+)
+
+// bweOveruseThreshold is the threshold, in milliseconds of filtered
+// one-way delay gradient, above which the arrival-time filter declares
+// the link overused (and below whose negative it declares it underused).
+// The threshold is fixed: it keeps this value for the whole lifetime of
+// the estimator and is not adapted to the observed delay trend.
+const bweOveruseThreshold = 12.5
+
+// bweSendHistorySize bounds the ring buffer of outgoing packet
+// departure times kept to compute the one-way delay gradient, indexed
+// by transport-wide sequence number modulo its size.
+const bweSendHistorySize = 2048
+
+type bweSendRecord struct {
+	valid bool
+	seq   uint16
+	at    time.Time
+}
+
+// bandwidthEstimator implements a delay-based bandwidth estimator
+// (inter-group delay variation filter + overuse detector with a fixed
+// threshold, see bweOveruseThreshold)
+// combined with a loss-based AIMD controller, following the structure
+// of the Google congestion control algorithm used by WebRTC.
+type bandwidthEstimator struct {
+	sendHistory  [bweSendHistorySize]bweSendRecord
+	trend        float64
+	threshold    float64
+	targetBits   float64
+	lastDecrease time.Time
+}
+
+func newBandwidthEstimator() *bandwidthEstimator {
+	return &bandwidthEstimator{
+		threshold:  bweOveruseThreshold,
+		targetBits: bweMinBitrate,
+	}
+}
+
+// recordSend stores the departure time of an outgoing packet, keyed by
+// its transport-wide sequence number, so that it can later be paired
+// with the arrival time reported for the same sequence number.
+func (e *bandwidthEstimator) recordSend(seq uint16, now time.Time) {
+	r := &e.sendHistory[int(seq)%len(e.sendHistory)]
+	r.valid = true
+	r.seq = seq
+	r.at = now
+}
+
+func (e *bandwidthEstimator) sendTime(seq uint16) (time.Time, bool) {
+	r := &e.sendHistory[int(seq)%len(e.sendHistory)]
+	if !r.valid || r.seq != seq {
+		return time.Time{}, false
+	}
+	return r.at, true
+}
+
+// processFeedback updates the delay trend and loss ratio from a single
+// TWCC feedback packet and adjusts the target bitrate accordingly.
+func (e *bandwidthEstimator) processFeedback(fb *rtcp.TransportLayerCC, now time.Time) {
+	received, lost := e.countPackets(fb)
+
+	e.updateDelayTrend(fb, now)
+	e.applyAIMD(received, lost)
+}
+
+func (e *bandwidthEstimator) countPackets(fb *rtcp.TransportLayerCC) (received, lost int) {
+	received = len(fb.RecvDeltas)
+	total := int(fb.PacketStatusCount)
+	if total > received {
+		lost = total - received
+	}
+	return received, lost
+}
+
+// tccPacketStatuses decodes the packet status chunks of a
+// rtcp.TransportLayerCC feedback packet into one boolean per sequence
+// number in [BaseSequenceNumber, BaseSequenceNumber+PacketStatusCount),
+// true where the chunk marks that packet as received. RecvDeltas only
+// carries an entry for the received ones, in sequence order, so this is
+// what lets a RecvDeltas index be matched back to the transport-wide
+// sequence number it belongs to despite any gap left by a lost packet.
+func tccPacketStatuses(fb *rtcp.TransportLayerCC) []bool {
+	statuses := make([]bool, 0, fb.PacketStatusCount)
+
+	for _, chunk := range fb.PacketChunks {
+		switch c := chunk.(type) {
+		case *rtcp.RunLengthChunk:
+			received := c.PacketStatusSymbol != rtcp.TypeTCCPacketNotReceived
+			for i := uint16(0); i < c.RunLength && len(statuses) < int(fb.PacketStatusCount); i++ {
+				statuses = append(statuses, received)
+			}
+
+		case *rtcp.StatusVectorChunk:
+			for _, symbol := range c.SymbolList {
+				if len(statuses) >= int(fb.PacketStatusCount) {
+					break
+				}
+				statuses = append(statuses, symbol != rtcp.TypeTCCPacketNotReceived)
+			}
+		}
+	}
+
+	for len(statuses) < int(fb.PacketStatusCount) {
+		statuses = append(statuses, false)
+	}
+
+	return statuses
+}
+
+// updateDelayTrend computes, for each consecutive pair of packets this
+// feedback report has a matching departure time for, the inter-group
+// delay variation d(i) = [T(i)-T(i-1)] - [t(i)-t(i-1)], where T is the
+// client-reported arrival time and t is our own departure time. Unlike
+// raw arrival spacing, this cancels out the constant offset between the
+// two unsynchronized clocks and isolates queuing delay, which is what
+// the overuse detector needs to distinguish congestion from cadence.
+func (e *bandwidthEstimator) updateDelayTrend(fb *rtcp.TransportLayerCC, now time.Time) {
+	const filterGain = 0.01
+
+	statuses := tccPacketStatuses(fb)
+
+	var arrivalOffset time.Duration
+	var gradientSum float64
+	var gradientCount int
+	havePrev := false
+	var prevArrival time.Duration
+	var prevSent time.Time
+
+	deltaIdx := 0
+	for i, received := range statuses {
+		if !received {
+			havePrev = false
+			continue
+		}
+		if deltaIdx >= len(fb.RecvDeltas) {
+			break
+		}
+		d := fb.RecvDeltas[deltaIdx]
+		deltaIdx++
+
+		arrivalOffset += time.Duration(d.Delta) * 250 * time.Microsecond
+
+		seq := fb.BaseSequenceNumber + uint16(i)
+		sentAt, ok := e.sendTime(seq)
+		if !ok {
+			havePrev = false
+			continue
+		}
+
+		if havePrev {
+			arrivalDelta := arrivalOffset - prevArrival
+			sendDelta := sentAt.Sub(prevSent)
+			gradientSum += float64(arrivalDelta-sendDelta) / float64(time.Millisecond)
+			gradientCount++
+		}
+
+		prevArrival = arrivalOffset
+		prevSent = sentAt
+		havePrev = true
+	}
+
+	if gradientCount == 0 {
+		return
+	}
+
+	e.trend += filterGain * (gradientSum/float64(gradientCount) - e.trend)
+
+	switch {
+	case e.trend > e.threshold:
+		e.onOveruse(now)
+	case e.trend < -e.threshold:
+		e.onUnderuse()
+	}
+}
+
+func (e *bandwidthEstimator) onOveruse(now time.Time) {
+	if now.Sub(e.lastDecrease) < time.Second {
+		return
+	}
+	e.targetBits *= 0.85
+	e.lastDecrease = now
+	e.clampTarget()
+}
+
+func (e *bandwidthEstimator) onUnderuse() {
+	e.targetBits *= 1.05
+	e.clampTarget()
+}
+
+// applyAIMD folds packet loss into the estimate: a loss ratio above 10%
+// triggers a multiplicative decrease proportional to it, a loss ratio
+// below 2% allows an additive increase of 1 kbit/s, and anything in
+// between leaves the estimate unchanged, mirroring classic AIMD
+// congestion control.
+func (e *bandwidthEstimator) applyAIMD(received, lost int) {
+	total := received + lost
+	if total == 0 {
+		return
+	}
+
+	lossRatio := float64(lost) / float64(total)
+
+	switch {
+	case lossRatio > 0.1:
+		e.targetBits *= 1 - 0.5*lossRatio
+	case lossRatio < 0.02:
+		e.targetBits += 1000
+	}
+
+	e.clampTarget()
+}
+
+func (e *bandwidthEstimator) clampTarget() {
+	if e.targetBits < bweMinBitrate {
+		e.targetBits = bweMinBitrate
+	}
+	if e.targetBits > bweMaxBitrate {
+		e.targetBits = bweMaxBitrate
+	}
+}
+
+func (e *bandwidthEstimator) targetBitrate() uint64 {
+	return uint64(e.targetBits)
+}
@@ -7,8 +7,10 @@ import (
 
 	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
+	"github.com/pion/srtp/v2"
 
 	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/headers"
 	"github.com/bluenviron/gortsplib/v4/pkg/liberrors"
 )
 
@@ -25,9 +27,75 @@ type serverSessionMedia struct {
 	formats                map[uint8]*serverSessionFormat // record only
 	writePacketRTPInQueue  func([]byte) error
 	writePacketRTCPInQueue func([]byte) error
+
+	rtxCache    *rtxPacketCache          // play only
+	gapTrackers map[uint8]*rtxGapTracker // record only
+	rtxDone     chan struct{}
+
+	twccExtensionID uint8         // record only, 0 if not negotiated
+	twccReceiver    *twccReceiver // record only
+	twccFeedbackSeq uint32
+	bwEstimator     *bandwidthEstimator // play only
+
+	formatStats map[uint8]*formatStats
+	bitrate     bitrateMeter
+	statsDone   chan struct{}
+
+	// pendingSRTPKey is set by SETUP, before initialize() runs, when the
+	// Transport header or description negotiated an SRTP/SRTCP profile.
+	pendingSRTPProfile srtp.ProtectionProfile
+	pendingSRTPKey     []byte
+
+	srtpContexts *srtpContextMap // keyed by SSRC
+
+	// pendingTransport is set by SETUP, before initialize() runs, to the
+	// negotiated Transport header, so that initialize() can pull the SSM
+	// `source` parameter out of it.
+	pendingTransport *headers.Transport
+
+	ssmSource           net.IP // set by initialize() from pendingTransport, multicast only
+	unicastRTCPReadPort int    // RFC 5760 unicast feedback port, play only, allocated by initialize() when ssmSource is non-nil
+
+	// rtpRecordDeliver is the transport-specific (UDP vs TCP) delivery of
+	// an already-parsed Record-side RTP packet to its format, set once by
+	// start() and called from BindRTPReader so that the default
+	// interceptor's parse doubles as the only parse on the hot path. Left
+	// nil on the Play side, which has no formats to deliver to.
+	rtpRecordDeliver func(pkt *rtp.Packet, now time.Time)
+
+	// rtpReadChain and rtcpReadChain are the default-interceptor-wrapped
+	// terminal stages of the read chain, precomputed once by start() so
+	// that the per-packet hot path doesn't allocate a closure. See
+	// BindRTPReader/BindRTCPReader in interceptor.go.
+	rtpReadChain  readFunc
+	rtcpReadChain readFunc
 }
 
 func (sm *serverSessionMedia) initialize() {
+	sm.formatStats = make(map[uint8]*formatStats)
+	for _, forma := range sm.media.Formats {
+		sm.formatStats[forma.PayloadType()] = newFormatStatsTracker(forma.ClockRate())
+	}
+
+	// negotiated regardless of direction: Record uses it to generate TWCC
+	// feedback for what it receives, Play uses it to time-stamp what it
+	// sends so that feedback coming back can be matched to a departure time.
+	if id, ok := findTWCCExtensionID(sm.media); ok {
+		sm.twccExtensionID = id
+	}
+
+	if sm.pendingSRTPKey != nil {
+		sm.setSRTPKey(sm.pendingSRTPProfile, sm.pendingSRTPKey)
+	}
+
+	if sm.ss.state == ServerSessionStatePlay && sm.pendingTransport != nil &&
+		sm.ss.s.MulticastSSMEnabled && *sm.ss.setuppedTransport == TransportUDPMulticast {
+		if source, ok := ssmSourceFromTransport(sm.pendingTransport); ok {
+			sm.ssmSource = source
+			sm.unicastRTCPReadPort = sm.ss.s.udpRTCPListener.allocatePort()
+		}
+	}
+
 	if sm.ss.state == ServerSessionStatePreRecord {
 		sm.formats = make(map[uint8]*serverSessionFormat)
 		for _, forma := range sm.media.Formats {
@@ -37,6 +105,13 @@ func (sm *serverSessionMedia) initialize() {
 				onPacketRTP: func(*rtp.Packet) {},
 			}
 		}
+
+		if sm.ss.s.RTXEnabled {
+			sm.gapTrackers = make(map[uint8]*rtxGapTracker)
+			for payloadType := range sm.formats {
+				sm.gapTrackers[payloadType] = newRTXGapTracker()
+			}
+		}
 	}
 }
 
@@ -47,17 +122,55 @@ func (sm *serverSessionMedia) start() {
 		sf.start()
 	}
 
+	if sm.ss.s.RTXEnabled {
+		if sm.ss.state == ServerSessionStatePlay {
+			sm.rtxCache = newRTXPacketCache(sm.ss.s.RTXCacheSize)
+		} else {
+			sm.rtxDone = make(chan struct{})
+			go sm.runRTXGapChecker()
+		}
+	}
+
+	if sm.ss.state == ServerSessionStatePlay {
+		sm.bwEstimator = newBandwidthEstimator()
+	} else if sm.twccExtensionID != 0 {
+		sm.twccReceiver = newTWCCReceiver(sm.twccExtensionID, 0, 0)
+		go sm.runTWCCFeedbackSender()
+	}
+
+	sm.statsDone = make(chan struct{})
+	go sm.runStatsMeter()
+
+	// precompute the default-interceptor-wrapped read chains. These run
+	// the SSRC dispatch, stats updaters and SenderReport processing that
+	// used to be inlined in the read functions below, plus (for RTP) the
+	// final delivery to the format; they are now part of the same
+	// interceptor chain as anything registered with Server.AddInterceptor,
+	// just always present and innermost, after decryption. next is a trivial
+	// terminator: BindRTPReader/BindRTCPReader never need to call back
+	// into a second, re-parsing stage.
+	if sm.ss.state == ServerSessionStatePreRecord {
+		if *sm.ss.setuppedTransport == TransportTCP {
+			sm.rtpRecordDeliver = sm.deliverRTPRecordTCP
+		} else {
+			sm.rtpRecordDeliver = sm.deliverRTPRecordUDP
+		}
+	}
+	terminal := func([]byte) bool { return true }
+	sm.rtpReadChain = sm.BindRTPReader(terminal)
+	sm.rtcpReadChain = sm.BindRTCPReader(terminal)
+
 	switch *sm.ss.setuppedTransport {
 	case TransportUDP, TransportUDPMulticast:
-		sm.writePacketRTPInQueue = sm.writePacketRTPInQueueUDP
-		sm.writePacketRTCPInQueue = sm.writePacketRTCPInQueueUDP
+		sm.writePacketRTPInQueue = sm.bindRTPWriter(sm.BindRTPWriter(sm.writePacketRTPInQueueUDP))
+		sm.writePacketRTCPInQueue = sm.bindRTCPWriter(sm.writePacketRTCPInQueueUDP)
 
 		if *sm.ss.setuppedTransport == TransportUDP {
 			if sm.ss.state == ServerSessionStatePlay {
 				// firewall opening is performed with RTCP sender reports generated by ServerStream
 
 				// readers can send RTCP packets only
-				sm.ss.s.udpRTCPListener.addClient(sm.ss.author.ip(), sm.udpRTCPReadPort, sm.readRTCPUDPPlay)
+				sm.ss.s.udpRTCPListener.addClient(sm.ss.author.ip(), sm.udpRTCPReadPort, sm.bindRTCPReader(sm.readRTCPUDPPlay))
 			} else {
 				// open the firewall by sending empty packets to the counterpart.
 				byts, _ := (&rtp.Packet{Header: rtp.Header{Version: 2}}).Marshal()
@@ -66,25 +179,27 @@ func (sm *serverSessionMedia) start() {
 				byts, _ = (&rtcp.ReceiverReport{}).Marshal()
 				sm.ss.s.udpRTCPListener.write(byts, sm.udpRTCPWriteAddr) //nolint:errcheck
 
-				sm.ss.s.udpRTPListener.addClient(sm.ss.author.ip(), sm.udpRTPReadPort, sm.readRTPUDPRecord)
-				sm.ss.s.udpRTCPListener.addClient(sm.ss.author.ip(), sm.udpRTCPReadPort, sm.readRTCPUDPRecord)
+				sm.ss.s.udpRTPListener.addClient(sm.ss.author.ip(), sm.udpRTPReadPort, sm.bindRTPReader(sm.readRTPUDPRecord))
+				sm.ss.s.udpRTCPListener.addClient(sm.ss.author.ip(), sm.udpRTCPReadPort, sm.bindRTCPReader(sm.readRTCPUDPRecord))
 			}
+		} else if sm.ss.state == ServerSessionStatePlay {
+			sm.joinSSM()
 		}
 
 	case TransportTCP:
-		sm.writePacketRTPInQueue = sm.writePacketRTPInQueueTCP
-		sm.writePacketRTCPInQueue = sm.writePacketRTCPInQueueTCP
+		sm.writePacketRTPInQueue = sm.bindRTPWriter(sm.BindRTPWriter(sm.writePacketRTPInQueueTCP))
+		sm.writePacketRTCPInQueue = sm.bindRTCPWriter(sm.writePacketRTCPInQueueTCP)
 
 		if sm.ss.tcpCallbackByChannel == nil {
 			sm.ss.tcpCallbackByChannel = make(map[int]readFunc)
 		}
 
 		if sm.ss.state == ServerSessionStatePlay {
-			sm.ss.tcpCallbackByChannel[sm.tcpChannel] = sm.readRTPTCPPlay
-			sm.ss.tcpCallbackByChannel[sm.tcpChannel+1] = sm.readRTCPTCPPlay
+			sm.ss.tcpCallbackByChannel[sm.tcpChannel] = sm.bindRTPReader(sm.readRTPTCPPlay)
+			sm.ss.tcpCallbackByChannel[sm.tcpChannel+1] = sm.bindRTCPReader(sm.readRTCPTCPPlay)
 		} else {
-			sm.ss.tcpCallbackByChannel[sm.tcpChannel] = sm.readRTPTCPRecord
-			sm.ss.tcpCallbackByChannel[sm.tcpChannel+1] = sm.readRTCPTCPRecord
+			sm.ss.tcpCallbackByChannel[sm.tcpChannel] = sm.bindRTPReader(sm.readRTPTCPRecord)
+			sm.ss.tcpCallbackByChannel[sm.tcpChannel+1] = sm.bindRTCPReader(sm.readRTCPTCPRecord)
 		}
 	}
 }
@@ -93,13 +208,57 @@ func (sm *serverSessionMedia) stop() {
 	if *sm.ss.setuppedTransport == TransportUDP {
 		sm.ss.s.udpRTPListener.removeClient(sm.ss.author.ip(), sm.udpRTPReadPort)
 		sm.ss.s.udpRTCPListener.removeClient(sm.ss.author.ip(), sm.udpRTCPReadPort)
+	} else if *sm.ss.setuppedTransport == TransportUDPMulticast && sm.ss.state == ServerSessionStatePlay {
+		sm.leaveSSM()
 	}
 
+	if sm.rtxDone != nil {
+		close(sm.rtxDone)
+	}
+
+	if sm.twccReceiver != nil {
+		close(sm.twccReceiver.done)
+	}
+
+	close(sm.statsDone)
+
 	for _, sf := range sm.formats {
 		sf.stop()
 	}
 }
 
+// runRTXGapChecker periodically scans the record-side gap trackers and
+// requests retransmission of any sequence number still missing after
+// the rtxHoldOff period.
+func (sm *serverSessionMedia) runRTXGapChecker() {
+	ticker := time.NewTicker(rtxHoldOff)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			now := sm.ss.s.timeNow()
+			for payloadType, tracker := range sm.gapTrackers {
+				seqs := tracker.pending(now)
+				if len(seqs) == 0 {
+					continue
+				}
+
+				forma := sm.formats[payloadType]
+				senderSSRC, ok := forma.rtcpReceiver.SenderSSRC()
+				if !ok {
+					continue
+				}
+
+				sm.sendNACK(senderSSRC, seqs)
+			}
+
+		case <-sm.rtxDone:
+			return
+		}
+	}
+}
+
 func (sm *serverSessionMedia) findFormatWithSSRC(ssrc uint32) *serverSessionFormat {
 	for _, format := range sm.formats {
 		tssrc, ok := format.rtcpReceiver.SenderSSRC()
@@ -112,16 +271,40 @@ func (sm *serverSessionMedia) findFormatWithSSRC(ssrc uint32) *serverSessionForm
 
 func (sm *serverSessionMedia) writePacketRTPInQueueUDP(payload []byte) error {
 	atomic.AddUint64(sm.ss.bytesSent, uint64(len(payload)))
+	atomic.AddUint64(&sm.bitrate.sentWindowBytes, uint64(len(payload)))
+	sm.cacheRTXPacket(payload)
+	sm.recordTWCCSend(payload, sm.ss.s.timeNow())
+
+	payload, err := sm.encryptRTPOut(payload)
+	if err != nil {
+		return err
+	}
+
 	return sm.ss.s.udpRTPListener.write(payload, sm.udpRTPWriteAddr)
 }
 
 func (sm *serverSessionMedia) writePacketRTCPInQueueUDP(payload []byte) error {
 	atomic.AddUint64(sm.ss.bytesSent, uint64(len(payload)))
+
+	payload, err := sm.encryptRTCPOut(payload)
+	if err != nil {
+		return err
+	}
+
 	return sm.ss.s.udpRTCPListener.write(payload, sm.udpRTCPWriteAddr)
 }
 
 func (sm *serverSessionMedia) writePacketRTPInQueueTCP(payload []byte) error {
 	atomic.AddUint64(sm.ss.bytesSent, uint64(len(payload)))
+	atomic.AddUint64(&sm.bitrate.sentWindowBytes, uint64(len(payload)))
+	sm.cacheRTXPacket(payload)
+	sm.recordTWCCSend(payload, sm.ss.s.timeNow())
+
+	payload, err := sm.encryptRTPOut(payload)
+	if err != nil {
+		return err
+	}
+
 	sm.ss.tcpFrame.Channel = sm.tcpChannel
 	sm.ss.tcpFrame.Payload = payload
 	sm.ss.tcpConn.nconn.SetWriteDeadline(time.Now().Add(sm.ss.s.WriteTimeout))
@@ -130,6 +313,12 @@ func (sm *serverSessionMedia) writePacketRTPInQueueTCP(payload []byte) error {
 
 func (sm *serverSessionMedia) writePacketRTCPInQueueTCP(payload []byte) error {
 	atomic.AddUint64(sm.ss.bytesSent, uint64(len(payload)))
+
+	payload, err := sm.encryptRTCPOut(payload)
+	if err != nil {
+		return err
+	}
+
 	sm.ss.tcpFrame.Channel = sm.tcpChannel + 1
 	sm.ss.tcpFrame.Payload = payload
 	sm.ss.tcpConn.nconn.SetWriteDeadline(time.Now().Add(sm.ss.s.WriteTimeout))
@@ -146,20 +335,14 @@ func (sm *serverSessionMedia) readRTCPUDPPlay(payload []byte) bool {
 		return false
 	}
 
-	packets, err := rtcp.Unmarshal(payload)
-	if err != nil {
-		sm.ss.onDecodeError(err)
+	payload, ok := sm.decryptRTCPIn(payload)
+	if !ok {
 		return false
 	}
 
-	now := sm.ss.s.timeNow()
-	atomic.StoreInt64(sm.ss.udpLastPacketTime, now.Unix())
-
-	for _, pkt := range packets {
-		sm.onPacketRTCP(pkt)
-	}
+	atomic.StoreInt64(sm.ss.udpLastPacketTime, sm.ss.s.timeNow().Unix())
 
-	return true
+	return sm.rtcpReadChain(payload)
 }
 
 func (sm *serverSessionMedia) readRTPUDPRecord(payload []byte) bool {
@@ -172,25 +355,15 @@ func (sm *serverSessionMedia) readRTPUDPRecord(payload []byte) bool {
 		return false
 	}
 
-	pkt := &rtp.Packet{}
-	err := pkt.Unmarshal(payload)
-	if err != nil {
-		sm.ss.onDecodeError(err)
-		return false
-	}
-
-	forma, ok := sm.formats[pkt.PayloadType]
+	payload, ok := sm.decryptRTPIn(payload)
 	if !ok {
-		sm.ss.onDecodeError(liberrors.ErrServerRTPPacketUnknownPayloadType{PayloadType: pkt.PayloadType})
 		return false
 	}
 
-	now := sm.ss.s.timeNow()
-	atomic.StoreInt64(sm.ss.udpLastPacketTime, now.Unix())
+	atomic.StoreInt64(sm.ss.udpLastPacketTime, sm.ss.s.timeNow().Unix())
+	atomic.AddUint64(&sm.bitrate.receivedWindowBytes, uint64(plen))
 
-	forma.readRTPUDP(pkt, now)
-
-	return true
+	return sm.rtpReadChain(payload)
 }
 
 func (sm *serverSessionMedia) readRTCPUDPRecord(payload []byte) bool {
@@ -203,27 +376,14 @@ func (sm *serverSessionMedia) readRTCPUDPRecord(payload []byte) bool {
 		return false
 	}
 
-	packets, err := rtcp.Unmarshal(payload)
-	if err != nil {
-		sm.ss.onDecodeError(err)
+	payload, ok := sm.decryptRTCPIn(payload)
+	if !ok {
 		return false
 	}
 
-	now := sm.ss.s.timeNow()
-	atomic.StoreInt64(sm.ss.udpLastPacketTime, now.Unix())
-
-	for _, pkt := range packets {
-		if sr, ok := pkt.(*rtcp.SenderReport); ok {
-			format := sm.findFormatWithSSRC(sr.SSRC)
-			if format != nil {
-				format.rtcpReceiver.ProcessSenderReport(sr, now)
-			}
-		}
-
-		sm.onPacketRTCP(pkt)
-	}
+	atomic.StoreInt64(sm.ss.udpLastPacketTime, sm.ss.s.timeNow().Unix())
 
-	return true
+	return sm.rtcpReadChain(payload)
 }
 
 func (sm *serverSessionMedia) readRTPTCPPlay(_ []byte) bool {
@@ -236,36 +396,23 @@ func (sm *serverSessionMedia) readRTCPTCPPlay(payload []byte) bool {
 		return false
 	}
 
-	packets, err := rtcp.Unmarshal(payload)
-	if err != nil {
-		sm.ss.onDecodeError(err)
+	payload, ok := sm.decryptRTCPIn(payload)
+	if !ok {
 		return false
 	}
 
-	for _, pkt := range packets {
-		sm.onPacketRTCP(pkt)
-	}
-
-	return true
+	return sm.rtcpReadChain(payload)
 }
 
 func (sm *serverSessionMedia) readRTPTCPRecord(payload []byte) bool {
-	pkt := &rtp.Packet{}
-	err := pkt.Unmarshal(payload)
-	if err != nil {
-		sm.ss.onDecodeError(err)
-		return false
-	}
-
-	forma, ok := sm.formats[pkt.PayloadType]
+	payload, ok := sm.decryptRTPIn(payload)
 	if !ok {
-		sm.ss.onDecodeError(liberrors.ErrServerRTPPacketUnknownPayloadType{PayloadType: pkt.PayloadType})
 		return false
 	}
 
-	forma.readRTPTCP(pkt)
+	atomic.AddUint64(&sm.bitrate.receivedWindowBytes, uint64(len(payload)))
 
-	return true
+	return sm.rtpReadChain(payload)
 }
 
 func (sm *serverSessionMedia) readRTCPTCPRecord(payload []byte) bool {
@@ -274,24 +421,35 @@ func (sm *serverSessionMedia) readRTCPTCPRecord(payload []byte) bool {
 		return false
 	}
 
-	packets, err := rtcp.Unmarshal(payload)
-	if err != nil {
-		sm.ss.onDecodeError(err)
+	payload, ok := sm.decryptRTCPIn(payload)
+	if !ok {
 		return false
 	}
 
-	now := sm.ss.s.timeNow()
+	return sm.rtcpReadChain(payload)
+}
 
-	for _, pkt := range packets {
-		if sr, ok := pkt.(*rtcp.SenderReport); ok {
-			format := sm.findFormatWithSSRC(sr.SSRC)
-			if format != nil {
-				format.rtcpReceiver.ProcessSenderReport(sr, now)
-			}
-		}
+// deliverRTPRecordUDP and deliverRTPRecordTCP forward an already-parsed,
+// Record-side RTP packet to its format. They are called directly from
+// BindRTPReader via rtpRecordDeliver, once the default interceptor has
+// parsed the packet to do its own bookkeeping, so the packet is never
+// unmarshaled twice.
+func (sm *serverSessionMedia) deliverRTPRecordUDP(pkt *rtp.Packet, now time.Time) {
+	forma, ok := sm.formats[pkt.PayloadType]
+	if !ok {
+		sm.ss.onDecodeError(liberrors.ErrServerRTPPacketUnknownPayloadType{PayloadType: pkt.PayloadType})
+		return
+	}
+
+	forma.readRTPUDP(pkt, now)
+}
 
-		sm.onPacketRTCP(pkt)
+func (sm *serverSessionMedia) deliverRTPRecordTCP(pkt *rtp.Packet, _ time.Time) {
+	forma, ok := sm.formats[pkt.PayloadType]
+	if !ok {
+		sm.ss.onDecodeError(liberrors.ErrServerRTPPacketUnknownPayloadType{PayloadType: pkt.PayloadType})
+		return
 	}
 
-	return true
+	forma.readRTPTCP(pkt)
 }
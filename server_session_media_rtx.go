@@ -0,0 +1,259 @@
+package gortsplib
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+// defaultRTXCacheSize is used when Server.RTXCacheSize is left unset.
+const defaultRTXCacheSize = 512
+
+// rtxMaxPacketAge is the maximum age of a cached packet that is still
+// worth retransmitting. Resending anything older is pointless since the
+// reader will likely have already concealed or skipped past it.
+const rtxMaxPacketAge = 500 * time.Millisecond
+
+type rtxCacheEntry struct {
+	valid   bool
+	seq     uint16
+	payload []byte
+	sentAt  time.Time
+}
+
+// rtxPacketCache is a bounded ring buffer that keeps the most recently
+// sent RTP packets of a media, indexed by sequence number modulo the
+// cache size, so that they can be resent upon a Generic NACK request.
+// store is called from the packet-write path while get is called from
+// the RTCP-read path via handleNACK, so access to entries is guarded by
+// mutex, mirroring rtxGapTracker below.
+type rtxPacketCache struct {
+	mutex   sync.Mutex
+	entries []rtxCacheEntry
+}
+
+func newRTXPacketCache(size int) *rtxPacketCache {
+	if size <= 0 {
+		size = defaultRTXCacheSize
+	}
+	return &rtxPacketCache{
+		entries: make([]rtxCacheEntry, size),
+	}
+}
+
+func (c *rtxPacketCache) store(seq uint16, payload []byte, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry := &c.entries[int(seq)%len(c.entries)]
+	entry.valid = true
+	entry.seq = seq
+	entry.sentAt = now
+
+	if cap(entry.payload) >= len(payload) {
+		entry.payload = entry.payload[:len(payload)]
+	} else {
+		entry.payload = make([]byte, len(payload))
+	}
+	copy(entry.payload, payload)
+}
+
+func (c *rtxPacketCache) get(seq uint16, now time.Time) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry := &c.entries[int(seq)%len(c.entries)]
+	if !entry.valid || entry.seq != seq {
+		return nil, false
+	}
+	if now.Sub(entry.sentAt) > rtxMaxPacketAge {
+		return nil, false
+	}
+
+	payload := make([]byte, len(entry.payload))
+	copy(payload, entry.payload)
+	return payload, true
+}
+
+// rtpSequenceNumber extracts the sequence number from a marshaled RTP
+// packet without fully unmarshaling it, since the write path only has
+// the serialized payload available.
+func rtpSequenceNumber(payload []byte) (uint16, bool) {
+	if len(payload) < 4 {
+		return 0, false
+	}
+	return uint16(payload[2])<<8 | uint16(payload[3]), true
+}
+
+// cacheRTXPacket stores an outgoing RTP packet in the RTX cache, if one
+// is active for this media, so that it can be resent upon request.
+func (sm *serverSessionMedia) cacheRTXPacket(payload []byte) {
+	if sm.rtxCache == nil {
+		return
+	}
+
+	seq, ok := rtpSequenceNumber(payload)
+	if !ok {
+		return
+	}
+
+	sm.rtxCache.store(seq, payload, sm.ss.s.timeNow())
+}
+
+// handleNACK resends any cached packets referenced by a Generic NACK
+// feedback packet, skipping entries that are no longer in the cache or
+// that have become too old to be useful.
+func (sm *serverSessionMedia) handleNACK(nack *rtcp.TransportLayerNack) {
+	if sm.rtxCache == nil {
+		return
+	}
+
+	now := sm.ss.s.timeNow()
+
+	for _, pair := range nack.Nacks {
+		for _, seq := range pair.PacketList() {
+			payload, ok := sm.rtxCache.get(seq, now)
+			if !ok {
+				continue
+			}
+			sm.writePacketRTPInQueue(payload) //nolint:errcheck
+		}
+	}
+}
+
+// rtxHoldOff is the delay before the first Generic NACK is sent for a
+// given gap, roughly an RTT, to avoid nacking packets that are merely
+// reordered in flight rather than lost.
+const rtxHoldOff = 100 * time.Millisecond
+
+// rtxMaxNACKAttempts bounds how many times a given sequence number is
+// re-nacked before rtxGapTracker gives up on it. Without a cap, a packet
+// that was genuinely lost (rather than merely reordered) stays in
+// missing forever, since only a later packet carrying that exact
+// sequence number clears it, and gets re-nacked on every runRTXGapChecker
+// tick for the rest of the session.
+const rtxMaxNACKAttempts = 5
+
+// missingPacket tracks how long a sequence number has been missing and
+// how many times it has already been nacked for it, so pending can give
+// up on it once rtxMaxNACKAttempts is reached.
+type missingPacket struct {
+	firstSeen time.Time
+	attempts  int
+}
+
+// rtxGapTracker detects holes in an incoming RTP sequence number stream
+// (Record direction) and reports them so that Generic NACK feedback can
+// be generated for the still-missing ones. push is called from the
+// RTP read path while pending is polled from runRTXGapChecker's own
+// goroutine, so access to the mutable fields is guarded by mutex.
+type rtxGapTracker struct {
+	mutex   sync.Mutex
+	hasLast bool
+	lastSeq uint16
+	missing map[uint16]*missingPacket
+}
+
+func newRTXGapTracker() *rtxGapTracker {
+	return &rtxGapTracker{
+		missing: make(map[uint16]*missingPacket),
+	}
+}
+
+func (t *rtxGapTracker) push(seq uint16, now time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !t.hasLast {
+		t.hasLast = true
+		t.lastSeq = seq
+		return
+	}
+
+	diff := seq - t.lastSeq
+
+	switch {
+	case diff == 1:
+		t.lastSeq = seq
+
+	case diff > 1 && diff < 0x8000:
+		for missing := t.lastSeq + 1; missing != seq; missing++ {
+			t.missing[missing] = &missingPacket{firstSeen: now}
+		}
+		t.lastSeq = seq
+
+	default:
+		// old or duplicate packet; if it fills a known gap, clear it.
+		delete(t.missing, seq)
+	}
+}
+
+// pending returns the sequence numbers that have been missing for at
+// least rtxHoldOff and are therefore worth nacking, and gives up on (and
+// stops tracking) any sequence number that has already been nacked
+// rtxMaxNACKAttempts times, so a packet that was genuinely lost rather
+// than reordered doesn't get nacked forever.
+func (t *rtxGapTracker) pending(now time.Time) []uint16 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var seqs []uint16
+	for seq, m := range t.missing {
+		if now.Sub(m.firstSeen) < rtxHoldOff {
+			continue
+		}
+
+		if m.attempts >= rtxMaxNACKAttempts {
+			delete(t.missing, seq)
+			continue
+		}
+
+		m.attempts++
+		seqs = append(seqs, seq)
+	}
+	return seqs
+}
+
+// nackPairsFromSeqs packs a set of missing sequence numbers into the
+// minimum number of PID+BLP pairs used by a Generic NACK packet.
+func nackPairsFromSeqs(seqs []uint16) []rtcp.NackPair {
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	var pairs []rtcp.NackPair
+
+	for _, seq := range seqs {
+		if len(pairs) > 0 {
+			delta := seq - pairs[len(pairs)-1].PacketID
+			if delta >= 1 && delta <= 16 {
+				pairs[len(pairs)-1].LostPackets |= 1 << (delta - 1)
+				continue
+			}
+		}
+
+		pairs = append(pairs, rtcp.NackPair{PacketID: seq})
+	}
+
+	return pairs
+}
+
+// sendNACK emits a Generic NACK RTCP packet for the given sender SSRC
+// covering the provided missing sequence numbers.
+func (sm *serverSessionMedia) sendNACK(senderSSRC uint32, seqs []uint16) {
+	if len(seqs) == 0 {
+		return
+	}
+
+	nack := &rtcp.TransportLayerNack{
+		SenderSSRC: senderSSRC,
+		Nacks:      nackPairsFromSeqs(seqs),
+	}
+
+	byts, err := nack.Marshal()
+	if err != nil {
+		return
+	}
+
+	sm.writePacketRTCPInQueue(byts) //nolint:errcheck
+}
@@ -0,0 +1,131 @@
+package gortsplib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNackPairsFromSeqs(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		seqs []uint16
+		want []uint16 // expected PacketIDs, in order
+	}{
+		{
+			name: "empty",
+			seqs: nil,
+			want: nil,
+		},
+		{
+			name: "single",
+			seqs: []uint16{10},
+			want: []uint16{10},
+		},
+		{
+			name: "within one BLP window",
+			seqs: []uint16{10, 11, 16, 26},
+			want: []uint16{10},
+		},
+		{
+			name: "unsorted input",
+			seqs: []uint16{26, 11, 10, 16},
+			want: []uint16{10},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			pairs := nackPairsFromSeqs(append([]uint16(nil), ca.seqs...))
+
+			var got []uint16
+			for _, p := range pairs {
+				got = append(got, p.PacketID)
+			}
+			require.Equal(t, ca.want, got)
+		})
+	}
+
+	pairs := nackPairsFromSeqs([]uint16{10, 11, 16, 26})
+	require.Equal(t, uint16(0b1000_0000_0010_0001), pairs[0].LostPackets)
+}
+
+func TestRTXPacketCache(t *testing.T) {
+	now := time.Now()
+	c := newRTXPacketCache(4)
+
+	c.store(1, []byte{1, 2, 3}, now)
+
+	payload, ok := c.get(1, now)
+	require.True(t, ok)
+	require.Equal(t, []byte{1, 2, 3}, payload)
+
+	// mutating the returned slice must not corrupt the cache entry.
+	payload[0] = 0xff
+	payload2, ok := c.get(1, now)
+	require.True(t, ok)
+	require.Equal(t, []byte{1, 2, 3}, payload2)
+
+	_, ok = c.get(2, now)
+	require.False(t, ok)
+
+	_, ok = c.get(1, now.Add(rtxMaxPacketAge+time.Millisecond))
+	require.False(t, ok)
+}
+
+func TestRTXPacketCacheConcurrent(t *testing.T) {
+	now := time.Now()
+	c := newRTXPacketCache(8)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			c.store(uint16(i), []byte{byte(i), byte(i + 1)}, now)
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		c.get(uint16(i), now) //nolint:errcheck
+	}
+	<-done
+}
+
+func TestRTXGapTrackerGivesUpAfterMaxAttempts(t *testing.T) {
+	tr := newRTXGapTracker()
+	now := time.Now()
+
+	tr.push(10, now)
+	tr.push(12, now) // 11 is now missing
+
+	for i := 0; i < rtxMaxNACKAttempts; i++ {
+		now = now.Add(rtxHoldOff)
+		seqs := tr.pending(now)
+		require.Equal(t, []uint16{11}, seqs)
+	}
+
+	// the next poll gives up on 11 instead of nacking it forever.
+	now = now.Add(rtxHoldOff)
+	seqs := tr.pending(now)
+	require.Empty(t, seqs)
+
+	tr.mutex.Lock()
+	_, stillTracked := tr.missing[11]
+	tr.mutex.Unlock()
+	require.False(t, stillTracked)
+}
+
+func TestRTXGapTrackerFillsGapOnLateArrival(t *testing.T) {
+	tr := newRTXGapTracker()
+	now := time.Now()
+
+	tr.push(10, now)
+	tr.push(12, now)
+
+	seqs := tr.pending(now.Add(rtxHoldOff))
+	require.Equal(t, []uint16{11}, seqs)
+
+	tr.push(11, now) // late arrival fills the gap
+
+	seqs = tr.pending(now.Add(2 * rtxHoldOff))
+	require.Empty(t, seqs)
+}
@@ -0,0 +1,221 @@
+package gortsplib
+
+import (
+	"sync"
+
+	"github.com/pion/srtp/v2"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/liberrors"
+)
+
+// srtpContext wraps the pion SRTP/SRTCP contexts used to encrypt and
+// decrypt a single SSRC of a serverSessionMedia. SRTP maintains a
+// rollover counter and replay window per SSRC (RFC 3711 §3.2.3), so a
+// media with more than one active SSRC (e.g. a primary stream alongside
+// an RTX stream) needs one context per SSRC rather than a single shared
+// one, even though every SSRC of the media derives from the same
+// negotiated master key.
+type srtpContext struct {
+	rtpCtx  *srtp.Context
+	rtcpCtx *srtp.Context
+}
+
+func newSRTPContext(profile srtp.ProtectionProfile, key []byte) (*srtpContext, error) {
+	rtpCtx, err := srtp.CreateContext(key[:profile.KeyLen()], key[profile.KeyLen():], profile)
+	if err != nil {
+		return nil, err
+	}
+
+	rtcpCtx, err := srtp.CreateContext(key[:profile.KeyLen()], key[profile.KeyLen():], profile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &srtpContext{rtpCtx: rtpCtx, rtcpCtx: rtcpCtx}, nil
+}
+
+func (c *srtpContext) encryptRTP(payload []byte) ([]byte, error) {
+	return c.rtpCtx.EncryptRTP(nil, payload, nil)
+}
+
+func (c *srtpContext) decryptRTP(payload []byte) ([]byte, error) {
+	return c.rtpCtx.DecryptRTP(nil, payload, nil)
+}
+
+func (c *srtpContext) encryptRTCP(payload []byte) ([]byte, error) {
+	return c.rtcpCtx.EncryptRTCP(nil, payload, nil)
+}
+
+func (c *srtpContext) decryptRTCP(payload []byte) ([]byte, error) {
+	return c.rtcpCtx.DecryptRTCP(nil, payload, nil)
+}
+
+// srtpContextMap is the set of per-SSRC srtpContexts negotiated for a
+// media. getOrCreate is called from both the RTP and RTCP read/write
+// paths, which run on different goroutines (separate udpRTPListener /
+// udpRTCPListener callbacks, same as every other per-media state in this
+// file), so access to contexts is guarded by mutex, mirroring
+// rtxGapTracker/twccReceiver elsewhere in this file set.
+type srtpContextMap struct {
+	mutex    sync.Mutex
+	profile  srtp.ProtectionProfile
+	key      []byte
+	contexts map[uint32]*srtpContext
+}
+
+func newSRTPContextMap(profile srtp.ProtectionProfile, key []byte) *srtpContextMap {
+	return &srtpContextMap{
+		profile:  profile,
+		key:      key,
+		contexts: make(map[uint32]*srtpContext),
+	}
+}
+
+// getOrCreate returns the srtpContext for the given SSRC, creating it on
+// first use. This mirrors the lookup-or-create role findFormatWithSSRC
+// plays for RTCP sender reports, but keyed per SSRC instead of per
+// format since that's the granularity SRTP state is kept at.
+func (m *srtpContextMap) getOrCreate(ssrc uint32) (*srtpContext, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if ctx, ok := m.contexts[ssrc]; ok {
+		return ctx, nil
+	}
+
+	ctx, err := newSRTPContext(m.profile, m.key)
+	if err != nil {
+		return nil, err
+	}
+
+	m.contexts[ssrc] = ctx
+	return ctx, nil
+}
+
+// setSRTPKey records the crypto parameters negotiated by SETUP (the
+// Transport header `crypto` parameter, or SDES/MIKEY key material
+// carried in the description). Once set, srtpContextForSSRC builds a
+// context the first time each SSRC of this media is seen.
+func (sm *serverSessionMedia) setSRTPKey(profile srtp.ProtectionProfile, key []byte) {
+	sm.srtpContexts = newSRTPContextMap(profile, key)
+}
+
+// srtpContextForSSRC returns the srtpContext for the given SSRC,
+// creating it on first use.
+func (sm *serverSessionMedia) srtpContextForSSRC(ssrc uint32) (*srtpContext, error) {
+	return sm.srtpContexts.getOrCreate(ssrc)
+}
+
+// rtpSSRC extracts the SSRC from a marshaled RTP packet without fully
+// unmarshaling it, mirroring rtpSequenceNumber and rtpPayloadType.
+func rtpSSRC(payload []byte) (uint32, bool) {
+	if len(payload) < 12 {
+		return 0, false
+	}
+	return uint32(payload[8])<<24 | uint32(payload[9])<<16 | uint32(payload[10])<<8 | uint32(payload[11]), true
+}
+
+// rtcpSSRC extracts the sender/chunk SSRC of the first packet in a
+// marshaled (possibly compound) RTCP payload, which RFC 3711 leaves
+// unencrypted in the packet header for every common RTCP packet type.
+func rtcpSSRC(payload []byte) (uint32, bool) {
+	if len(payload) < 8 {
+		return 0, false
+	}
+	return uint32(payload[4])<<24 | uint32(payload[5])<<16 | uint32(payload[6])<<8 | uint32(payload[7]), true
+}
+
+// encryptRTPOut runs an outgoing, already-serialized RTP packet through
+// the SRTP context of its SSRC, if SRTP has been negotiated for this
+// media.
+func (sm *serverSessionMedia) encryptRTPOut(payload []byte) ([]byte, error) {
+	if sm.srtpContexts == nil {
+		return payload, nil
+	}
+
+	ssrc, ok := rtpSSRC(payload)
+	if !ok {
+		return payload, nil
+	}
+
+	ctx, err := sm.srtpContextForSSRC(ssrc)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctx.encryptRTP(payload)
+}
+
+// encryptRTCPOut runs an outgoing, already-serialized RTCP packet
+// through the SRTCP context of its sender SSRC, if any.
+func (sm *serverSessionMedia) encryptRTCPOut(payload []byte) ([]byte, error) {
+	if sm.srtpContexts == nil {
+		return payload, nil
+	}
+
+	ssrc, ok := rtcpSSRC(payload)
+	if !ok {
+		return payload, nil
+	}
+
+	ctx, err := sm.srtpContextForSSRC(ssrc)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctx.encryptRTCP(payload)
+}
+
+// decryptRTPIn authenticates and decrypts an incoming SRTP packet,
+// surfacing any authentication/decryption failure through the same
+// onDecodeError path used for malformed RTP.
+func (sm *serverSessionMedia) decryptRTPIn(payload []byte) ([]byte, bool) {
+	if sm.srtpContexts == nil {
+		return payload, true
+	}
+
+	ssrc, ok := rtpSSRC(payload)
+	if !ok {
+		return payload, true
+	}
+
+	ctx, err := sm.srtpContextForSSRC(ssrc)
+	if err != nil {
+		sm.ss.onDecodeError(liberrors.ErrServerSRTPDecrypt{Err: err})
+		return nil, false
+	}
+
+	dec, err := ctx.decryptRTP(payload)
+	if err != nil {
+		sm.ss.onDecodeError(liberrors.ErrServerSRTPDecrypt{Err: err})
+		return nil, false
+	}
+
+	return dec, true
+}
+
+// decryptRTCPIn authenticates and decrypts an incoming SRTCP packet.
+func (sm *serverSessionMedia) decryptRTCPIn(payload []byte) ([]byte, bool) {
+	if sm.srtpContexts == nil {
+		return payload, true
+	}
+
+	ssrc, ok := rtcpSSRC(payload)
+	if !ok {
+		return payload, true
+	}
+
+	ctx, err := sm.srtpContextForSSRC(ssrc)
+	if err != nil {
+		sm.ss.onDecodeError(liberrors.ErrServerSRTPDecrypt{Err: err})
+		return nil, false
+	}
+
+	dec, err := ctx.decryptRTCP(payload)
+	if err != nil {
+		sm.ss.onDecodeError(liberrors.ErrServerSRTPDecrypt{Err: err})
+		return nil, false
+	}
+
+	return dec, true
+}
@@ -0,0 +1,79 @@
+package gortsplib
+
+import (
+	"net"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/headers"
+)
+
+// ssmSourceFromTransport extracts the `source` parameter negotiated by a
+// SETUP request's Transport header (RFC 7826 §18.54 / RFC 2326bis), so
+// that initialize() can record it and joinSSM can then join the matching
+// (S,G) via IGMPv3/MLDv2 source filters instead of Any-Source Multicast.
+//
+// It returns ok == false when the client did not request SSM, in which
+// case the media falls back to the ASM join the multicast listener
+// already performs.
+func ssmSourceFromTransport(th *headers.Transport) (net.IP, bool) {
+	if th.Source == nil {
+		return nil, false
+	}
+
+	ip := net.ParseIP(*th.Source)
+	if ip == nil {
+		return nil, false
+	}
+
+	return ip, true
+}
+
+// ssmResponseSource returns the value that must be echoed back in the
+// SETUP response's Transport header `source` parameter, confirming to
+// the client which source address the session joined.
+func (sm *serverSessionMedia) ssmResponseSource() *string {
+	if sm.ssmSource == nil {
+		return nil
+	}
+
+	s := sm.ssmSource.String()
+	return &s
+}
+
+// joinSSM issues the IGMPv3/MLDv2 source-specific join for this media's
+// multicast group and, since SSM lets receivers rely on RFC 5760
+// unicast feedback instead of looping RTCP back onto the group, starts
+// accepting RR/feedback from this session's receiver on its dedicated
+// unicast RTCP port. It is called once from start(), and is a no-op
+// unless the server enabled SSM and the SETUP negotiated a source
+// address.
+func (sm *serverSessionMedia) joinSSM() {
+	if !sm.ss.s.MulticastSSMEnabled || sm.ssmSource == nil {
+		return
+	}
+
+	iface := sm.ss.s.MulticastInterface
+
+	sm.ss.s.udpRTPListener.joinSSMSource(sm.udpRTPWriteAddr, iface, sm.ssmSource)   //nolint:errcheck
+	sm.ss.s.udpRTCPListener.joinSSMSource(sm.udpRTCPWriteAddr, iface, sm.ssmSource) //nolint:errcheck
+
+	if sm.unicastRTCPReadPort != 0 {
+		sm.ss.s.udpRTCPListener.addClient(sm.ss.author.ip(), sm.unicastRTCPReadPort, sm.bindRTCPReader(sm.readRTCPUDPPlay))
+	}
+}
+
+// leaveSSM undoes joinSSM, issuing the matching IGMPv3/MLDv2 leave and
+// removing the per-session unicast RTCP feedback client.
+func (sm *serverSessionMedia) leaveSSM() {
+	if !sm.ss.s.MulticastSSMEnabled || sm.ssmSource == nil {
+		return
+	}
+
+	iface := sm.ss.s.MulticastInterface
+
+	sm.ss.s.udpRTPListener.leaveSSMSource(sm.udpRTPWriteAddr, iface, sm.ssmSource)   //nolint:errcheck
+	sm.ss.s.udpRTCPListener.leaveSSMSource(sm.udpRTCPWriteAddr, iface, sm.ssmSource) //nolint:errcheck
+
+	if sm.unicastRTCPReadPort != 0 {
+		sm.ss.s.udpRTCPListener.removeClient(sm.ss.author.ip(), sm.unicastRTCPReadPort)
+	}
+}
@@ -0,0 +1,45 @@
+package gortsplib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/headers"
+)
+
+func TestSSMSourceFromTransport(t *testing.T) {
+	source := "192.168.1.1"
+
+	for _, ca := range []struct {
+		name   string
+		th     *headers.Transport
+		wantOK bool
+		wantIP string
+	}{
+		{
+			name:   "no source",
+			th:     &headers.Transport{},
+			wantOK: false,
+		},
+		{
+			name:   "valid source",
+			th:     &headers.Transport{Source: &source},
+			wantOK: true,
+			wantIP: "192.168.1.1",
+		},
+		{
+			name:   "invalid source",
+			th:     &headers.Transport{Source: func() *string { s := "not-an-ip"; return &s }()},
+			wantOK: false,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			ip, ok := ssmSourceFromTransport(ca.th)
+			require.Equal(t, ca.wantOK, ok)
+			if ca.wantOK {
+				require.Equal(t, ca.wantIP, ip.String())
+			}
+		})
+	}
+}
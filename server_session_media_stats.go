@@ -0,0 +1,325 @@
+package gortsplib
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+// statsBitrateWindow is the sliding window used to compute incoming and
+// outgoing bitrate. Byte counters are reset at the end of every window
+// by runStatsMeter, so the hot path only ever performs an atomic add.
+const statsBitrateWindow = 1 * time.Second
+
+// formatStats holds the lock-free, per-format counters that back
+// FormatStats. All fields are updated with atomics from the existing
+// read/write paths in server_session_media.go, so reading them from
+// Stats() never contends with the hot path.
+type formatStats struct {
+	packetsSent     uint64
+	packetsReceived uint64
+	packetsLost     int64
+	jitterBits      uint64 // math.Float64bits of the RFC 3550 §A.8 jitter estimate, in RTP timestamp units
+	lastTransit     int64
+	hasLastTransit  uint32 // 0 or 1, used as a gate for the jitter bootstrap
+	hasLastSeq      uint32 // 0 or 1
+	lastSeq         uint32
+	lastSRUnixNano  int64
+	lastRRUnixNano  int64
+	rttNanos        int64
+
+	clockRate int
+}
+
+// sentWindowBytes/receivedWindowBytes accumulate bytes for the current
+// bitrate window; sentBitrate/receivedBitrate hold the last computed
+// rate in bits per second.
+type bitrateMeter struct {
+	sentWindowBytes     uint64
+	receivedWindowBytes uint64
+	sentBitrate         uint64
+	receivedBitrate     uint64
+}
+
+// FormatStats holds the statistics collected for a single format of a
+// setupped media.
+type FormatStats struct {
+	PacketsSent        uint64
+	PacketsReceived    uint64
+	PacketsLost        int64
+	Jitter             time.Duration
+	LastSenderReport   time.Time
+	LastReceiverReport time.Time
+	RTT                time.Duration
+}
+
+// MediaStats holds the statistics collected for a single setupped
+// media, aggregated across its formats. BytesSent and BytesReceived are
+// the exception: they are the totals of the whole session, and hold the
+// same value in every MediaStats of a ServerSessionStats.
+type MediaStats struct {
+	BytesSent       uint64
+	BytesReceived   uint64
+	BitrateSent     uint64 // bits per second
+	BitrateReceived uint64 // bits per second
+	Formats         map[uint8]FormatStats
+}
+
+// ServerSessionStats holds the statistics collected for a ServerSession,
+// one entry per setupped media.
+type ServerSessionStats struct {
+	Medias []MediaStats
+}
+
+func newFormatStatsTracker(clockRate int) *formatStats {
+	return &formatStats{clockRate: clockRate}
+}
+
+func (s *formatStats) recordSent() {
+	atomic.AddUint64(&s.packetsSent, 1)
+}
+
+// recordReceived updates the received-packet counter, the cumulative
+// loss estimate (derived from sequence number jumps) and the
+// interarrival jitter estimate (RFC 3550 §A.8).
+func (s *formatStats) recordReceived(seq uint16, rtpTime uint32, arrival time.Time) {
+	atomic.AddUint64(&s.packetsReceived, 1)
+
+	if atomic.SwapUint32(&s.hasLastSeq, 1) == 1 {
+		diff := int32(int16(seq - uint16(atomic.LoadUint32(&s.lastSeq))))
+		if diff != 1 {
+			atomic.AddInt64(&s.packetsLost, int64(diff)-1)
+		}
+	}
+	atomic.StoreUint32(&s.lastSeq, uint32(seq))
+
+	if s.clockRate <= 0 {
+		return
+	}
+
+	transit := arrival.UnixNano()/int64(time.Second/time.Duration(s.clockRate)) - int64(rtpTime)
+
+	if atomic.SwapUint32(&s.hasLastTransit, 1) == 1 {
+		d := transit - atomic.LoadInt64(&s.lastTransit)
+		if d < 0 {
+			d = -d
+		}
+
+		for {
+			old := atomic.LoadUint64(&s.jitterBits)
+			jitter := math.Float64frombits(old)
+			jitter += (float64(d) - jitter) / 16
+			if atomic.CompareAndSwapUint64(&s.jitterBits, old, math.Float64bits(jitter)) {
+				break
+			}
+		}
+	}
+	atomic.StoreInt64(&s.lastTransit, transit)
+}
+
+func (s *formatStats) recordSenderReport(now time.Time) {
+	atomic.StoreInt64(&s.lastSRUnixNano, now.UnixNano())
+}
+
+// recordReceiverReportRTT computes the round-trip time from the
+// LSR/DLSR fields of a freshly-received RTCP report that references an
+// SR we previously sent, per RFC 3550 §6.4.1.
+func (s *formatStats) recordReceiverReportRTT(lsr, dlsr uint32, now time.Time) {
+	atomic.StoreInt64(&s.lastRRUnixNano, now.UnixNano())
+
+	if lsr == 0 {
+		return
+	}
+
+	nowNTP := ntpMiddle32(now)
+	rtt := int64(nowNTP-lsr-dlsr) * int64(time.Second) >> 16
+	if rtt > 0 {
+		atomic.StoreInt64(&s.rttNanos, rtt)
+	}
+}
+
+func (s *formatStats) snapshot() FormatStats {
+	fs := FormatStats{
+		PacketsSent:     atomic.LoadUint64(&s.packetsSent),
+		PacketsReceived: atomic.LoadUint64(&s.packetsReceived),
+		PacketsLost:     atomic.LoadInt64(&s.packetsLost),
+		Jitter:          time.Duration(math.Float64frombits(atomic.LoadUint64(&s.jitterBits))) * time.Second,
+		RTT:             time.Duration(atomic.LoadInt64(&s.rttNanos)),
+	}
+
+	if s.clockRate > 0 {
+		fs.Jitter /= time.Duration(s.clockRate)
+	}
+	if v := atomic.LoadInt64(&s.lastSRUnixNano); v != 0 {
+		fs.LastSenderReport = time.Unix(0, v)
+	}
+	if v := atomic.LoadInt64(&s.lastRRUnixNano); v != 0 {
+		fs.LastReceiverReport = time.Unix(0, v)
+	}
+
+	return fs
+}
+
+// ntpMiddle32 returns the middle 32 bits of the 64-bit NTP timestamp
+// for t, matching the LSR field format used in SR/RR exchanges.
+func ntpMiddle32(t time.Time) uint32 {
+	const ntpEpochOffset = 2208988800 // seconds between 1900 and 1970
+
+	s := uint64(t.Unix()+ntpEpochOffset) << 32
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	ntp := s | frac
+
+	return uint32(ntp >> 16)
+}
+
+// runStatsMeter periodically turns the raw byte counters accumulated on
+// the hot path into a bits-per-second rate for BitrateSent/BitrateReceived.
+func (sm *serverSessionMedia) runStatsMeter() {
+	ticker := time.NewTicker(statsBitrateWindow)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			sent := atomic.SwapUint64(&sm.bitrate.sentWindowBytes, 0)
+			received := atomic.SwapUint64(&sm.bitrate.receivedWindowBytes, 0)
+
+			atomic.StoreUint64(&sm.bitrate.sentBitrate, sent*8/uint64(statsBitrateWindow/time.Second))
+			atomic.StoreUint64(&sm.bitrate.receivedBitrate, received*8/uint64(statsBitrateWindow/time.Second))
+
+			if sm.ss.state == ServerSessionStateRecord {
+				sm.sendReceiverReports()
+			}
+
+		case <-sm.statsDone:
+			return
+		}
+	}
+}
+
+// sendReceiverReports emits a symmetric RTCP Receiver Report for every
+// format of a Record session that has a known sender SSRC, completing
+// the SR/RR feedback loop alongside the existing ProcessSenderReport
+// handling.
+func (sm *serverSessionMedia) sendReceiverReports() {
+	for payloadType, forma := range sm.formats {
+		ssrc, ok := forma.rtcpReceiver.SenderSSRC()
+		if !ok {
+			continue
+		}
+
+		fs, ok := sm.formatStats[payloadType]
+		if !ok {
+			continue
+		}
+
+		rr := generateReceiverReport(ssrc, fs)
+
+		byts, err := rr.Marshal()
+		if err != nil {
+			continue
+		}
+
+		sm.writePacketRTCPInQueue(byts) //nolint:errcheck
+	}
+}
+
+// stats returns a MediaStats snapshot for this media.
+func (sm *serverSessionMedia) stats() MediaStats {
+	ms := MediaStats{
+		BitrateSent:     atomic.LoadUint64(&sm.bitrate.sentBitrate),
+		BitrateReceived: atomic.LoadUint64(&sm.bitrate.receivedBitrate),
+		Formats:         make(map[uint8]FormatStats, len(sm.formatStats)),
+	}
+
+	for payloadType, fs := range sm.formatStats {
+		ms.Formats[payloadType] = fs.snapshot()
+	}
+
+	return ms
+}
+
+// Stats returns the current RTP statistics (loss, jitter, bitrate, RTT)
+// for every media setupped on this session.
+func (ss *ServerSession) Stats() ServerSessionStats {
+	stats := ServerSessionStats{
+		Medias: make([]MediaStats, len(ss.setuppedMedias)),
+	}
+
+	for i, sm := range ss.setuppedMedias {
+		stats.Medias[i] = sm.stats()
+		stats.Medias[i].BytesSent = atomic.LoadUint64(ss.bytesSent)
+		stats.Medias[i].BytesReceived = atomic.LoadUint64(ss.bytesReceived)
+	}
+
+	return stats
+}
+
+// generateReceiverReport builds an RTCP Receiver Report for a Record
+// format, so that Record sessions emit symmetric RR feedback just like
+// the receiver-side SR processing already performed in
+// rtcpReceiver.ProcessSenderReport.
+func generateReceiverReport(ssrc uint32, fs *formatStats) *rtcp.ReceiverReport {
+	snap := fs.snapshot()
+
+	var fractionLost uint8
+	if snap.PacketsLost > 0 && snap.PacketsReceived > 0 {
+		fractionLost = uint8(256 * snap.PacketsLost / int64(snap.PacketsReceived+uint64(snap.PacketsLost)))
+	}
+
+	return &rtcp.ReceiverReport{
+		SSRC: ssrc,
+		Reports: []rtcp.ReceptionReport{
+			{
+				SSRC:               ssrc,
+				FractionLost:       fractionLost,
+				TotalLost:          uint32(maxInt64(snap.PacketsLost, 0)),
+				LastSequenceNumber: uint32(atomic.LoadUint32(&fs.lastSeq)),
+				Jitter:             uint32(snap.Jitter.Seconds() * float64(fs.clockRate)),
+				LastSenderReport:   ntpMiddle32(snap.LastSenderReport),
+			},
+		},
+	}
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// recordReceiverReportRTT applies the LSR/DLSR fields of an incoming
+// RTCP Receiver Report (Play side) to every tracked format, deriving
+// the round-trip time as described in RFC 3550 §6.4.1.
+func (sm *serverSessionMedia) recordReceiverReportRTT(rr *rtcp.ReceiverReport, now time.Time) {
+	for _, report := range rr.Reports {
+		for _, fs := range sm.formatStats {
+			fs.recordReceiverReportRTT(report.LastSenderReport, report.Delay, now)
+		}
+	}
+}
+
+// recordSentStats updates the per-format sent-packet counter for an
+// outgoing, already-serialized RTP packet.
+func (sm *serverSessionMedia) recordSentStats(payload []byte) {
+	pt, ok := rtpPayloadType(payload)
+	if !ok {
+		return
+	}
+
+	if fs, ok := sm.formatStats[pt]; ok {
+		fs.recordSent()
+	}
+}
+
+// rtpPayloadType extracts the payload type from a marshaled RTP packet
+// without fully unmarshaling it, mirroring rtpSequenceNumber.
+func rtpPayloadType(payload []byte) (uint8, bool) {
+	if len(payload) < 2 {
+		return 0, false
+	}
+	return payload[1] & 0x7f, true
+}
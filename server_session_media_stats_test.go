@@ -0,0 +1,46 @@
+package gortsplib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNTPMiddle32(t *testing.T) {
+	// 2024-01-01T00:00:00Z, exactly on the second, so the fractional part is zero.
+	tm := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	got := ntpMiddle32(tm)
+
+	const ntpEpochOffset = 2208988800
+	secs := uint64(tm.Unix() + ntpEpochOffset)
+	want := uint32((secs << 32) >> 16)
+
+	require.Equal(t, want, got)
+}
+
+func TestFormatStatsRecordReceivedJitter(t *testing.T) {
+	fs := newFormatStatsTracker(90000)
+
+	now := time.Now()
+	fs.recordReceived(1000, 0, now)
+	fs.recordReceived(1001, 3000, now.Add(33*time.Millisecond))
+	fs.recordReceived(1002, 6000, now.Add(66*time.Millisecond))
+
+	snap := fs.snapshot()
+	require.Equal(t, uint64(3), snap.PacketsReceived)
+	require.Equal(t, int64(0), snap.PacketsLost)
+	require.GreaterOrEqual(t, snap.Jitter, time.Duration(0))
+}
+
+func TestFormatStatsRecordReceivedLoss(t *testing.T) {
+	fs := newFormatStatsTracker(90000)
+
+	now := time.Now()
+	fs.recordReceived(1000, 0, now)
+	fs.recordReceived(1005, 0, now) // 4 packets (1001-1004) never arrived
+
+	snap := fs.snapshot()
+	require.Equal(t, int64(4), snap.PacketsLost)
+}
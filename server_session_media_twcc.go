@@ -0,0 +1,314 @@
+package gortsplib
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+)
+
+// twccExtensionURI is the RTP header extension URI that signals
+// transport-wide sequence numbers, as used by the TWCC feedback loop.
+const twccExtensionURI = "http://www.ietf.org/id/draft-holmer-rmcat-transport-wide-cc-extensions-01"
+
+// twccFeedbackInterval is the maximum time between two TWCC feedback
+// packets sent for a Record session.
+const twccFeedbackInterval = 100 * time.Millisecond
+
+// twccFeedbackPacketCount is the number of received packets that, once
+// reached, triggers an early TWCC feedback packet instead of waiting
+// for twccFeedbackInterval to elapse.
+const twccFeedbackPacketCount = 30
+
+type twccArrival struct {
+	transportSeq uint16
+	arrivedAt    time.Time
+}
+
+// twccReceiver accumulates arrival times of transport-wide sequence
+// numbers on the Record side and periodically flushes them as
+// rtcp.TransportLayerCC feedback packets. push is called from the RTP
+// read path while flush is called both from that same read path (once
+// the batch threshold is hit) and from runTWCCFeedbackSender's own
+// ticker goroutine, so arrivals is guarded by mutex.
+type twccReceiver struct {
+	extensionID uint8
+	senderSSRC  uint32
+	mediaSSRC   uint32
+
+	mutex    sync.Mutex
+	arrivals []twccArrival
+	done     chan struct{}
+}
+
+func newTWCCReceiver(extensionID uint8, senderSSRC, mediaSSRC uint32) *twccReceiver {
+	return &twccReceiver{
+		extensionID: extensionID,
+		senderSSRC:  senderSSRC,
+		mediaSSRC:   mediaSSRC,
+		done:        make(chan struct{}),
+	}
+}
+
+func (r *twccReceiver) push(seq uint16, now time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.arrivals = append(r.arrivals, twccArrival{transportSeq: seq, arrivedAt: now})
+}
+
+func (r *twccReceiver) flush() []twccArrival {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	arrivals := r.arrivals
+	r.arrivals = nil
+	return arrivals
+}
+
+func (r *twccReceiver) len() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.arrivals)
+}
+
+// findTWCCExtensionID looks for an "a=extmap" attribute advertising the
+// transport-wide CC URI and returns its negotiated local identifier.
+func findTWCCExtensionID(media *description.Media) (uint8, bool) {
+	for _, attr := range media.Attributes {
+		if attr.Key != "extmap" {
+			continue
+		}
+
+		fields := strings.Fields(attr.Value)
+		if len(fields) != 2 || fields[1] != twccExtensionURI {
+			continue
+		}
+
+		id, err := strconv.Atoi(fields[0])
+		if err != nil || id <= 0 || id > 255 {
+			continue
+		}
+
+		return uint8(id), true
+	}
+
+	return 0, false
+}
+
+// readTWCCExtension extracts the transport-wide sequence number carried
+// in the given RTP packet's header extension, if present.
+func readTWCCExtension(pkt *rtp.Packet, extensionID uint8) (uint16, bool) {
+	ext := pkt.GetExtension(extensionID)
+	if len(ext) < 2 {
+		return 0, false
+	}
+	return uint16(ext[0])<<8 | uint16(ext[1]), true
+}
+
+// runTWCCFeedbackSender periodically builds and sends a
+// rtcp.TransportLayerCC packet out of the accumulated arrivals, either
+// when the interval elapses or when enough packets have piled up.
+func (sm *serverSessionMedia) runTWCCFeedbackSender() {
+	ticker := time.NewTicker(twccFeedbackInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			sm.flushTWCCFeedback()
+
+		case <-sm.twccReceiver.done:
+			return
+		}
+	}
+}
+
+func (sm *serverSessionMedia) flushTWCCFeedback() {
+	arrivals := sm.twccReceiver.flush()
+	if len(arrivals) == 0 {
+		return
+	}
+
+	pkt := buildTWCCFeedback(sm.twccReceiver.senderSSRC, sm.twccReceiver.mediaSSRC, arrivals, sm.twccSeqCounter())
+
+	byts, err := pkt.Marshal()
+	if err != nil {
+		return
+	}
+
+	sm.writePacketRTCPInQueue(byts) //nolint:errcheck
+}
+
+func (sm *serverSessionMedia) twccSeqCounter() uint8 {
+	return uint8(atomic.AddUint32(&sm.twccFeedbackSeq, 1))
+}
+
+// tccSmallDeltaMaxUnits and tccLargeDeltaMinUnits/MaxUnits bound the two
+// delta encodings of the transport-wide CC draft, each unit being
+// 250 microseconds: a small delta is a single unsigned byte (0 to
+// 63.75ms), a large delta a signed 16-bit value (-8192ms to
+// +8191.75ms). A delta outside even the large-delta range is clamped,
+// which only happens for gaps far beyond anything twccFeedbackInterval
+// would let accumulate.
+const (
+	tccSmallDeltaMaxUnits  = 255
+	tccLargeDeltaMinUnits  = -32768
+	tccLargeDeltaMaxUnits  = 32767
+	tccRunLengthChunkLimit = 0x1fff // 13-bit run length field
+)
+
+// tccDeltaUnitsAndType converts an inter-arrival gap into the
+// (units, type) pair buildTWCCFeedback stores in a RecvDelta, choosing
+// the small-delta encoding when it fits and falling back to the wider
+// large-delta encoding otherwise.
+func tccDeltaUnitsAndType(d time.Duration) (int64, uint16) {
+	units := d.Microseconds() / 250
+
+	if units >= 0 && units <= tccSmallDeltaMaxUnits {
+		return units, rtcp.TypeTCCPacketReceivedSmallDelta
+	}
+
+	if units < tccLargeDeltaMinUnits {
+		units = tccLargeDeltaMinUnits
+	} else if units > tccLargeDeltaMaxUnits {
+		units = tccLargeDeltaMaxUnits
+	}
+
+	return units, rtcp.TypeTCCPacketReceivedLargeDelta
+}
+
+// buildTWCCFeedback converts a batch of packet arrivals into a
+// rtcp.TransportLayerCC feedback packet, per the transport-wide CC
+// draft referenced by twccExtensionURI. The status chunks span every
+// transport-wide sequence number between the lowest and the highest one
+// seen in this batch, with a "not received" symbol for any sequence
+// number in that range that never arrived — this is what lets
+// PacketStatusCount exceed len(RecvDeltas) by the number of packets
+// genuinely lost in transit, which countPackets in bandwidth_estimator.go
+// relies on to drive its loss-based AIMD branch.
+func buildTWCCFeedback(senderSSRC, mediaSSRC uint32, arrivals []twccArrival, fbPktCount uint8) *rtcp.TransportLayerCC {
+	pkt := &rtcp.TransportLayerCC{
+		SenderSSRC: senderSSRC,
+		MediaSSRC:  mediaSSRC,
+		FbPktCount: fbPktCount,
+	}
+
+	if len(arrivals) == 0 {
+		return pkt
+	}
+
+	sort.Slice(arrivals, func(i, j int) bool { return arrivals[i].transportSeq < arrivals[j].transportSeq })
+
+	base := arrivals[0].transportSeq
+	last := arrivals[len(arrivals)-1].transportSeq
+	statusCount := int(last-base) + 1
+
+	arrivalBySeq := make(map[uint16]time.Time, len(arrivals))
+	for _, a := range arrivals {
+		arrivalBySeq[a.transportSeq] = a.arrivedAt
+	}
+
+	pkt.BaseSequenceNumber = base
+	pkt.ReferenceTime = uint32(arrivals[0].arrivedAt.UnixNano() / int64(64*time.Millisecond))
+	pkt.PacketStatusCount = uint16(statusCount)
+
+	var runSymbol uint16
+	var runLength uint16
+	haveRun := false
+	havePrevArrival := false
+	var prevArrival time.Time
+
+	flushRun := func() {
+		if !haveRun {
+			return
+		}
+		pkt.PacketChunks = append(pkt.PacketChunks, &rtcp.RunLengthChunk{
+			PacketStatusSymbol: runSymbol,
+			RunLength:          runLength,
+		})
+	}
+
+	for i := 0; i < statusCount; i++ {
+		seq := base + uint16(i)
+
+		arrivedAt, received := arrivalBySeq[seq]
+
+		var symbol uint16
+		if !received {
+			symbol = rtcp.TypeTCCPacketNotReceived
+		} else {
+			var delta time.Duration
+			if havePrevArrival {
+				delta = arrivedAt.Sub(prevArrival)
+			}
+
+			units, deltaType := tccDeltaUnitsAndType(delta)
+			symbol = deltaType
+
+			pkt.RecvDeltas = append(pkt.RecvDeltas, &rtcp.RecvDelta{
+				Type:  deltaType,
+				Delta: units,
+			})
+
+			prevArrival = arrivedAt
+			havePrevArrival = true
+		}
+
+		if haveRun && symbol == runSymbol && runLength < tccRunLengthChunkLimit {
+			runLength++
+			continue
+		}
+
+		flushRun()
+		runSymbol = symbol
+		runLength = 1
+		haveRun = true
+	}
+	flushRun()
+
+	return pkt
+}
+
+// recordTWCCSend extracts the transport-wide sequence number carried by
+// an outgoing RTP packet, if the Play side negotiated TWCC, and records
+// its departure time in the bandwidth estimator so that the matching
+// arrival report received later can be turned into a genuine one-way
+// delay gradient rather than raw arrival spacing.
+func (sm *serverSessionMedia) recordTWCCSend(payload []byte, now time.Time) {
+	if sm.bwEstimator == nil || sm.twccExtensionID == 0 {
+		return
+	}
+
+	pkt := &rtp.Packet{}
+	if pkt.Unmarshal(payload) != nil {
+		return
+	}
+
+	seq, ok := readTWCCExtension(pkt, sm.twccExtensionID)
+	if !ok {
+		return
+	}
+
+	sm.bwEstimator.recordSend(seq, now)
+}
+
+// handleTWCCFeedback feeds an incoming TWCC feedback packet (Play side)
+// into the bandwidth estimator and reports the resulting target bitrate
+// through the session's onBitrateEstimate callback, if one is set.
+func (sm *serverSessionMedia) handleTWCCFeedback(fb *rtcp.TransportLayerCC) {
+	if sm.bwEstimator == nil {
+		return
+	}
+
+	sm.bwEstimator.processFeedback(fb, sm.ss.s.timeNow())
+
+	if sm.ss.onBitrateEstimate != nil {
+		sm.ss.onBitrateEstimate(sm.bwEstimator.targetBitrate())
+	}
+}
@@ -0,0 +1,73 @@
+package gortsplib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildTWCCFeedbackAllReceived(t *testing.T) {
+	base := time.Now()
+	arrivals := []twccArrival{
+		{transportSeq: 10, arrivedAt: base},
+		{transportSeq: 11, arrivedAt: base.Add(5 * time.Millisecond)},
+		{transportSeq: 12, arrivedAt: base.Add(10 * time.Millisecond)},
+	}
+
+	pkt := buildTWCCFeedback(1, 2, arrivals, 7)
+
+	require.Equal(t, uint32(1), pkt.SenderSSRC)
+	require.Equal(t, uint32(2), pkt.MediaSSRC)
+	require.Equal(t, uint8(7), pkt.FbPktCount)
+	require.Equal(t, uint16(10), pkt.BaseSequenceNumber)
+	require.Equal(t, uint16(3), pkt.PacketStatusCount)
+	require.Len(t, pkt.RecvDeltas, 3)
+
+	statuses := tccPacketStatuses(pkt)
+	require.Equal(t, []bool{true, true, true}, statuses)
+}
+
+func TestBuildTWCCFeedbackWithGap(t *testing.T) {
+	base := time.Now()
+	// seq 11 is missing.
+	arrivals := []twccArrival{
+		{transportSeq: 10, arrivedAt: base},
+		{transportSeq: 12, arrivedAt: base.Add(10 * time.Millisecond)},
+	}
+
+	pkt := buildTWCCFeedback(1, 2, arrivals, 1)
+
+	require.Equal(t, uint16(10), pkt.BaseSequenceNumber)
+	require.Equal(t, uint16(3), pkt.PacketStatusCount)
+	require.Len(t, pkt.RecvDeltas, 2)
+
+	statuses := tccPacketStatuses(pkt)
+	require.Equal(t, []bool{true, false, true}, statuses)
+
+	e := newBandwidthEstimator()
+	received, lost := e.countPackets(pkt)
+	require.Equal(t, 2, received)
+	require.Equal(t, 1, lost)
+}
+
+func TestBuildTWCCFeedbackLargeDelta(t *testing.T) {
+	base := time.Now()
+	arrivals := []twccArrival{
+		{transportSeq: 10, arrivedAt: base},
+		{transportSeq: 11, arrivedAt: base.Add(200 * time.Millisecond)}, // exceeds the 63.75ms small-delta range
+	}
+
+	pkt := buildTWCCFeedback(1, 2, arrivals, 1)
+
+	require.Len(t, pkt.RecvDeltas, 2)
+	require.Equal(t, rtcp.TypeTCCPacketReceivedLargeDelta, pkt.RecvDeltas[1].Type)
+}
+
+func TestBuildTWCCFeedbackEmpty(t *testing.T) {
+	pkt := buildTWCCFeedback(1, 2, nil, 0)
+	require.Equal(t, uint16(0), pkt.PacketStatusCount)
+	require.Empty(t, pkt.RecvDeltas)
+	require.Empty(t, pkt.PacketChunks)
+}